@@ -0,0 +1,91 @@
+package accountutils
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+
+	"berty.tech/berty/v2/go/pkg/accounttypes"
+)
+
+// seedFixtureAccount writes a minimal account directory under rootDir/accountID
+// covering every file ExportAccount knows about, so the round-trip test
+// exercises the whole exportedFiles list rather than just one of them.
+func seedFixtureAccount(t *testing.T, rootDir string, accountID string) map[string][]byte {
+	t.Helper()
+
+	accountDir := filepath.Join(rootDir, accountID)
+	if err := os.MkdirAll(accountDir, 0o700); err != nil {
+		t.Fatalf("MkdirAll() returned an error: %s", err)
+	}
+
+	metaBytes, err := proto.Marshal(&accounttypes.AccountMetadata{})
+	if err != nil {
+		t.Fatalf("marshaling the fixture account metadata returned an error: %s", err)
+	}
+
+	contents := map[string][]byte{
+		AccountMetafileName:       metaBytes,
+		AccountNetConfFileName:    []byte("fixture-net-conf"),
+		DatastoreFilename:         []byte("fixture-datastore-bytes"),
+		MessengerDatabaseFilename: []byte("fixture-messenger-bytes"),
+		DefaultPushKeyFilename:    []byte("fixture-push-key-bytes"),
+	}
+
+	for name, data := range contents {
+		if err := ioutil.WriteFile(filepath.Join(accountDir, name), data, 0o600); err != nil {
+			t.Fatalf("writing fixture file %q returned an error: %s", name, err)
+		}
+	}
+
+	return contents
+}
+
+func TestExportImportAccountRoundTrip(t *testing.T) {
+	srcRoot := t.TempDir()
+	dstRoot := t.TempDir()
+	accountID := "fixture-account"
+
+	fixture := seedFixtureAccount(t, srcRoot, accountID)
+
+	var archive bytes.Buffer
+	if _, err := ExportAccount(context.Background(), srcRoot, accountID, &archive, ExportOptions{Passphrase: "correct horse battery staple"}); err != nil {
+		t.Fatalf("ExportAccount() returned an error: %s", err)
+	}
+
+	if _, err := ImportAccount(context.Background(), dstRoot, &archive, ImportOptions{Passphrase: "correct horse battery staple"}); err != nil {
+		t.Fatalf("ImportAccount() returned an error: %s", err)
+	}
+
+	for name, want := range fixture {
+		got, err := ioutil.ReadFile(filepath.Join(dstRoot, accountID, name))
+		if err != nil {
+			t.Fatalf("reading imported file %q returned an error: %s", name, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("imported file %q = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestImportAccountRejectsWrongPassphrase(t *testing.T) {
+	srcRoot := t.TempDir()
+	dstRoot := t.TempDir()
+	accountID := "fixture-account"
+
+	seedFixtureAccount(t, srcRoot, accountID)
+
+	var archive bytes.Buffer
+	if _, err := ExportAccount(context.Background(), srcRoot, accountID, &archive, ExportOptions{Passphrase: "correct horse battery staple"}); err != nil {
+		t.Fatalf("ExportAccount() returned an error: %s", err)
+	}
+
+	if _, err := ImportAccount(context.Background(), dstRoot, &archive, ImportOptions{Passphrase: "wrong passphrase"}); err == nil {
+		t.Fatal("expected ImportAccount() to fail with the wrong passphrase")
+	}
+}
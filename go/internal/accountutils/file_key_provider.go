@@ -0,0 +1,115 @@
+package accountutils
+
+import (
+	crand "crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"golang.org/x/crypto/nacl/secretbox"
+
+	"berty.tech/berty/v2/go/internal/cryptoutil"
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+// FileKeyProviderConfig configures a FileKeyProvider.
+type FileKeyProviderConfig struct {
+	// SealedDir is the directory wrapped DEKs are written to, one file per
+	// name: <SealedDir>/<name>.sealed.
+	SealedDir string
+
+	// KEKFilePath, if set, is read to get the key-encryption-key. Takes
+	// precedence over KEK.
+	KEKFilePath string
+
+	// KEK is the key-encryption-key used to seal/unseal DEKs directly, for
+	// callers that already have it in hand (e.g. loaded from an env var by
+	// the caller). At least one of KEK / KEKFilePath must be set; if both
+	// are, KEKFilePath is used.
+	KEK []byte
+}
+
+// FileKeyProvider is a StorageKeyProvider for deployments that have neither
+// an OS keychain nor a Vault instance: the DEK is sealed with NaCl secretbox
+// under a KEK supplied out of band (env var or --storage.key-file), and the
+// sealed blob is kept next to the account directory.
+type FileKeyProvider struct {
+	cfg FileKeyProviderConfig
+	kek [32]byte
+}
+
+func NewFileKeyProvider(cfg FileKeyProviderConfig) (*FileKeyProvider, error) {
+	kekBytes := cfg.KEK
+	if cfg.KEKFilePath != "" {
+		var err error
+		kekBytes, err = ioutil.ReadFile(cfg.KEKFilePath)
+		if err != nil {
+			return nil, errcode.ErrBertyAccountFSError.Wrap(fmt.Errorf("unable to read storage key-file: %w", err))
+		}
+	}
+
+	if len(kekBytes) != 32 {
+		return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("storage key-encryption-key must be 32 bytes, got %d", len(kekBytes)))
+	}
+
+	p := &FileKeyProvider{cfg: cfg}
+	copy(p.kek[:], kekBytes)
+
+	return p, nil
+}
+
+func (p *FileKeyProvider) sealedPath(name string) string {
+	return fmt.Sprintf("%s/%s.sealed", p.cfg.SealedDir, name)
+}
+
+func (p *FileKeyProvider) Get(name string) ([]byte, error) {
+	return ioutil.ReadFile(p.sealedPath(name))
+}
+
+func (p *FileKeyProvider) Put(name string, key []byte) error {
+	var nonce [24]byte
+	if _, err := crand.Read(nonce[:]); err != nil {
+		return errcode.ErrCryptoKeyGeneration.Wrap(err)
+	}
+
+	sealed := secretbox.Seal(nonce[:], key, &nonce, &p.kek)
+
+	if err := os.MkdirAll(p.cfg.SealedDir, 0o700); err != nil {
+		return errcode.ErrInternal.Wrap(err)
+	}
+
+	return ioutil.WriteFile(p.sealedPath(name), sealed, 0o600)
+}
+
+func (p *FileKeyProvider) Unwrap(wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 24 {
+		return nil, errcode.ErrCryptoDecrypt.Wrap(fmt.Errorf("sealed key is too short"))
+	}
+
+	var nonce [24]byte
+	copy(nonce[:], wrapped[:24])
+
+	plaintext, ok := secretbox.Open(nil, wrapped[24:], &nonce, &p.kek)
+	if !ok {
+		return nil, errcode.ErrCryptoDecrypt.Wrap(fmt.Errorf("unable to unseal storage key: wrong key-encryption-key or corrupted file"))
+	}
+
+	return plaintext, nil
+}
+
+func (p *FileKeyProvider) DSN(dbPath string, key []byte) (string, error) {
+	return sqlCipherDSN(dbPath, key)
+}
+
+func (p *FileKeyProvider) Rotate(name string) ([]byte, error) {
+	keyData := make([]byte, cryptoutil.KeySize)
+	if _, err := crand.Read(keyData); err != nil {
+		return nil, errcode.ErrCryptoKeyGeneration.Wrap(err)
+	}
+
+	if err := p.Put(name, keyData); err != nil {
+		return nil, err
+	}
+
+	return keyData, nil
+}
@@ -0,0 +1,79 @@
+package accountutils
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFileKeyProviderPutGetUnwrapRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+
+	provider, err := NewFileKeyProvider(FileKeyProviderConfig{
+		SealedDir: dir,
+		KEK:       bytes.Repeat([]byte{0x42}, 32),
+	})
+	if err != nil {
+		t.Fatalf("NewFileKeyProvider() returned an error: %s", err)
+	}
+
+	key := bytes.Repeat([]byte{0x07}, 32)
+
+	if err := provider.Put("storage", key); err != nil {
+		t.Fatalf("Put() returned an error: %s", err)
+	}
+
+	wrapped, err := provider.Get("storage")
+	if err != nil {
+		t.Fatalf("Get() returned an error: %s", err)
+	}
+	if bytes.Equal(wrapped, key) {
+		t.Fatal("Get() returned the plaintext key: the sealed blob on disk must not equal it")
+	}
+
+	unwrapped, err := provider.Unwrap(wrapped)
+	if err != nil {
+		t.Fatalf("Unwrap() returned an error: %s", err)
+	}
+	if !bytes.Equal(unwrapped, key) {
+		t.Fatalf("Unwrap() = %x, want %x", unwrapped, key)
+	}
+}
+
+func TestFileKeyProviderUnwrapRejectsWrongKEK(t *testing.T) {
+	dir := t.TempDir()
+
+	sealer, err := NewFileKeyProvider(FileKeyProviderConfig{
+		SealedDir: dir,
+		KEK:       bytes.Repeat([]byte{0x01}, 32),
+	})
+	if err != nil {
+		t.Fatalf("NewFileKeyProvider() returned an error: %s", err)
+	}
+
+	if err := sealer.Put("storage", bytes.Repeat([]byte{0x07}, 32)); err != nil {
+		t.Fatalf("Put() returned an error: %s", err)
+	}
+
+	wrapped, err := sealer.Get("storage")
+	if err != nil {
+		t.Fatalf("Get() returned an error: %s", err)
+	}
+
+	wrongKEK, err := NewFileKeyProvider(FileKeyProviderConfig{
+		SealedDir: dir,
+		KEK:       bytes.Repeat([]byte{0x02}, 32),
+	})
+	if err != nil {
+		t.Fatalf("NewFileKeyProvider() returned an error: %s", err)
+	}
+
+	if _, err := wrongKEK.Unwrap(wrapped); err == nil {
+		t.Fatal("expected Unwrap() to fail with the wrong key-encryption-key")
+	}
+}
+
+func TestNewFileKeyProviderRejectsWrongSizedKEK(t *testing.T) {
+	if _, err := NewFileKeyProvider(FileKeyProviderConfig{SealedDir: t.TempDir(), KEK: []byte{0x01, 0x02}}); err == nil {
+		t.Fatal("expected NewFileKeyProvider() to reject a key-encryption-key that isn't 32 bytes")
+	}
+}
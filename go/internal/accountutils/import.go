@@ -0,0 +1,193 @@
+package accountutils
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"berty.tech/berty/v2/go/pkg/accounttypes"
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+// ImportOptions configures ImportAccount.
+type ImportOptions struct {
+	// Passphrase must match the one ExportAccount was called with.
+	Passphrase string
+
+	// Overwrite allows importing over an account directory that already
+	// exists. Without it, ImportAccount refuses to touch an existing
+	// account.
+	Overwrite bool
+
+	Logger *zap.Logger
+}
+
+// ImportAccount reads an archive produced by ExportAccount from r and
+// restores it under rootDir. Every file is written to a temporary path
+// first and only swapped into place (by atomic rename) once its SHA-256
+// checksum has been verified, so an interrupted import never leaves a
+// truncated file behind; files already swapped in stay in place. An entry
+// whose target already matches the manifest checksum is skipped, so
+// re-running an interrupted import with Overwrite set (the account
+// directory then already exists) resumes rather than redoing finished work.
+func ImportAccount(ctx context.Context, rootDir string, r io.Reader, opts ImportOptions) (*accounttypes.AccountMetadata, error) {
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+
+	tr := tar.NewReader(r)
+
+	manifest, sealedEntries, err := readImportArchive(tr)
+	if err != nil {
+		return nil, err
+	}
+
+	if manifest.SchemaVersion > ManifestSchemaVersion {
+		return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("archive manifest schema version %d is newer than this binary supports (%d)", manifest.SchemaVersion, ManifestSchemaVersion))
+	}
+
+	accountDir := filepath.Join(rootDir, manifest.AccountID)
+	if _, err := os.Stat(accountDir); err == nil && !opts.Overwrite {
+		return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("account %q already exists, pass Overwrite to replace it", manifest.AccountID))
+	}
+
+	if err := os.MkdirAll(accountDir, 0o700); err != nil {
+		return nil, errcode.ErrBertyAccountFSError.Wrap(err)
+	}
+
+	masterKey := deriveArchiveMasterKey(opts.Passphrase, manifest.KDFSalt)
+
+	for _, entry := range manifest.Entries {
+		if err := ctx.Err(); err != nil {
+			return nil, errcode.ErrInternal.Wrap(err)
+		}
+
+		if err := validateEntryName(entry.Name); err != nil {
+			return nil, err
+		}
+
+		targetPath := filepath.Join(accountDir, entry.Name)
+
+		if matchesOnDisk(targetPath, entry.SHA256) {
+			opts.Logger.Debug("import: entry already up to date, skipping", zap.String("name", entry.Name))
+			continue
+		}
+
+		sealed, ok := sealedEntries[entry.Name]
+		if !ok {
+			return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("archive is missing entry %q listed in its manifest", entry.Name))
+		}
+
+		plaintext, err := openArchiveEntry(masterKey, entry.Name, sealed)
+		if err != nil {
+			return nil, err
+		}
+
+		sum := sha256.Sum256(plaintext)
+		if hex.EncodeToString(sum[:]) != entry.SHA256 {
+			return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("checksum mismatch for entry %q", entry.Name))
+		}
+
+		if err := atomicWriteFile(targetPath, plaintext); err != nil {
+			return nil, err
+		}
+	}
+
+	return GetAccountMetaForName(rootDir, manifest.AccountID, opts.Logger)
+}
+
+// readImportArchive reads every tar entry into memory: manifest.json plus
+// the sealed "<name>.enc" file for each entry it lists. Account archives
+// are small enough (a handful of SQLite files) that streaming entry-by-entry
+// against the on-disk target isn't worth the added complexity.
+func readImportArchive(tr *tar.Reader) (*Manifest, map[string][]byte, error) {
+	var manifest *Manifest
+	sealedEntries := map[string][]byte{}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, errcode.ErrDeserialization.Wrap(err)
+		}
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, nil, errcode.ErrDeserialization.Wrap(err)
+		}
+
+		if hdr.Name == manifestEntryName {
+			manifest = &Manifest{}
+			if err := json.Unmarshal(data, manifest); err != nil {
+				return nil, nil, errcode.ErrDeserialization.Wrap(fmt.Errorf("unable to parse archive manifest: %w", err))
+			}
+			continue
+		}
+
+		sealedEntries[strings.TrimSuffix(hdr.Name, ".enc")] = data
+	}
+
+	if manifest == nil {
+		return nil, nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("archive is missing its manifest.json entry"))
+	}
+
+	return manifest, sealedEntries, nil
+}
+
+// validateEntryName rejects anything but a bare, known account file name.
+// entry.Name comes straight from the archive's manifest.json, which is
+// plaintext and not covered by the secretbox seal on the entries themselves,
+// so a crafted archive could otherwise set it to something like
+// "../../../../etc/cron.d/evil" and have atomicWriteFile's rename write
+// outside accountDir (a tar/zip-slip).
+func validateEntryName(name string) error {
+	if filepath.Base(name) != name {
+		return errcode.ErrInvalidInput.Wrap(fmt.Errorf("archive entry %q is not a bare file name", name))
+	}
+
+	for _, known := range exportedFiles {
+		if name == known {
+			return nil
+		}
+	}
+
+	return errcode.ErrInvalidInput.Wrap(fmt.Errorf("archive entry %q is not one of the expected account files", name))
+}
+
+func matchesOnDisk(path string, wantSHA256 string) bool {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:]) == wantSHA256
+}
+
+// atomicWriteFile writes data to a temporary file next to path and renames
+// it into place, so a crash mid-write never leaves a truncated account file
+// behind.
+func atomicWriteFile(path string, data []byte) error {
+	tmp := path + ".importing"
+
+	if err := ioutil.WriteFile(tmp, data, 0o600); err != nil {
+		return errcode.ErrBertyAccountFSError.Wrap(err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return errcode.ErrBertyAccountFSError.Wrap(err)
+	}
+
+	return nil
+}
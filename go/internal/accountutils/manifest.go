@@ -0,0 +1,51 @@
+package accountutils
+
+import "time"
+
+// ManifestSchemaVersion is bumped whenever the archive layout produced by
+// ExportAccount changes in a way ImportAccount needs to special-case.
+const ManifestSchemaVersion = 1
+
+// ManifestEntry describes one file of the account directory as it was
+// captured in the archive.
+type ManifestEntry struct {
+	// Name is the file's path relative to the account directory, e.g.
+	// "messenger.sqlite".
+	Name string `json:"name"`
+
+	// SHA256 is the hex-encoded checksum of the plaintext file contents,
+	// used by ImportAccount both to verify decryption and to skip entries
+	// that already match on disk when resuming.
+	SHA256 string `json:"sha_256"`
+
+	// Size is the plaintext file size in bytes.
+	Size int64 `json:"size"`
+}
+
+// SQLCipherParams records the parameters the SQLCipher databases in the
+// archive were opened with, as passed to ExportAccount. ImportAccount does
+// not read them: it restores the database files byte for byte.
+type SQLCipherParams struct {
+	CipherPageSize int `json:"cipher_page_size"`
+}
+
+// Manifest is the self-describing header of an account export archive,
+// stored as the first entry of the tar stream (manifest.json).
+type Manifest struct {
+	SchemaVersion int       `json:"schema_version"`
+	AccountID     string    `json:"account_id"`
+	CreatedAt     time.Time `json:"created_at"`
+
+	Entries   []ManifestEntry `json:"entries"`
+	SQLCipher SQLCipherParams `json:"sql_cipher"`
+
+	// KDFSalt is the Argon2id salt used to derive the archive's encryption
+	// key from the caller-supplied passphrase.
+	KDFSalt []byte `json:"kdf_salt"`
+
+	// PreviousManifestIDs links this export to the manifest(s) it was
+	// produced as an incremental diff against, if any. Berty doesn't yet
+	// generate diff exports, but the field is part of the format from the
+	// start so a future incremental exporter doesn't need a schema bump.
+	PreviousManifestIDs []string `json:"previous_manifest_ids,omitempty"`
+}
@@ -0,0 +1,292 @@
+// Package migrations implements a small, SQLCipher-safe SQL migration
+// runner for berty's messenger and replication databases. Unlike gorm's
+// AutoMigrate, every step here runs inside a single transaction and is
+// checksummed, so a half-applied migration on an encrypted file is always
+// either fully applied or not applied at all.
+package migrations
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+//go:embed sql/*.sql
+var embeddedSQL embed.FS
+
+// Migration is one numbered schema step, loaded from a <version>_<name>.up.sql
+// / <version>_<name>.down.sql pair.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+
+	// Checksum is the hex-encoded SHA-256 of Up, recorded in
+	// schema_migrations so a binary that ships a modified migration with
+	// the same version number is refused instead of silently reapplied.
+	Checksum string
+}
+
+// Load reads every <version>_<name>.up.sql / .down.sql pair out of an
+// embed.FS (the package's own embeddedSQL by default; tests may pass a
+// different FS), sorted by version ascending.
+func Load(sqlFS fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(sqlFS, "sql")
+	if err != nil {
+		return nil, errcode.ErrInternal.Wrap(err)
+	}
+
+	byVersion := map[int]*Migration{}
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		version, label, err := parseMigrationFilename(name)
+		if err != nil {
+			return nil, err
+		}
+
+		contents, err := fs.ReadFile(sqlFS, "sql/"+name)
+		if err != nil {
+			return nil, errcode.ErrInternal.Wrap(err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: label}
+			byVersion[version] = m
+		}
+
+		if direction == "up" {
+			m.Up = string(contents)
+			sum := sha256.Sum256(contents)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, errcode.ErrInternal.Wrap(fmt.Errorf("migration %d (%s) has a .down.sql but no .up.sql", m.Version, m.Name))
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+func parseMigrationFilename(name string) (version int, label string, err error) {
+	parts := strings.SplitN(name, "_", 2)
+	if len(parts) != 2 {
+		return 0, "", errcode.ErrInternal.Wrap(fmt.Errorf("migration filename %q is not of the form <version>_<name>.(up|down).sql", name))
+	}
+
+	version, err = strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, "", errcode.ErrInternal.Wrap(fmt.Errorf("migration filename %q does not start with a numeric version: %w", name, err))
+	}
+
+	label = strings.TrimSuffix(strings.TrimSuffix(parts[1], ".up.sql"), ".down.sql")
+
+	return version, label, nil
+}
+
+// AppliedMigration is one row of the schema_migrations table.
+type AppliedMigration struct {
+	Version   int
+	AppliedAt time.Time
+	Checksum  string
+}
+
+// Migrator applies Migrations in order against a *sql.DB, recording progress
+// in a schema_migrations table so it can resume from wherever a previous run
+// left off.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// New loads the migrations embedded in this package and returns a Migrator
+// for db.
+func New(db *sql.DB) (*Migrator, error) {
+	migrations, err := Load(embeddedSQL)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+func (m *Migrator) ensureMigrationsTable() error {
+	_, err := m.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL,
+		checksum TEXT NOT NULL
+	);`)
+	if err != nil {
+		return errcode.ErrDBWrite.Wrap(err)
+	}
+	return nil
+}
+
+// Applied returns every migration recorded as already applied, ordered by
+// version ascending.
+func (m *Migrator) Applied() ([]AppliedMigration, error) {
+	if err := m.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+
+	rows, err := m.db.Query(`SELECT version, applied_at, checksum FROM schema_migrations ORDER BY version ASC;`)
+	if err != nil {
+		return nil, errcode.ErrDBRead.Wrap(err)
+	}
+	defer rows.Close()
+
+	var applied []AppliedMigration
+	for rows.Next() {
+		var a AppliedMigration
+		if err := rows.Scan(&a.Version, &a.AppliedAt, &a.Checksum); err != nil {
+			return nil, errcode.ErrDBRead.Wrap(err)
+		}
+		applied = append(applied, a)
+	}
+
+	return applied, rows.Err()
+}
+
+// PlannedStep is one migration Plan would apply, returned for dry runs.
+type PlannedStep struct {
+	Version int
+	Name    string
+}
+
+// Plan reports, without applying anything, which migrations Up would run to
+// reach target (0 meaning "latest").
+func (m *Migrator) Plan(target int) ([]PlannedStep, error) {
+	applied, err := m.Applied()
+	if err != nil {
+		return nil, err
+	}
+
+	appliedVersions := map[int]string{}
+	for _, a := range applied {
+		appliedVersions[a.Version] = a.Checksum
+	}
+
+	if err := m.checkNotAhead(applied); err != nil {
+		return nil, err
+	}
+
+	var steps []PlannedStep
+	for _, mig := range m.migrations {
+		if target != 0 && mig.Version > target {
+			break
+		}
+		if checksum, ok := appliedVersions[mig.Version]; ok {
+			if checksum != mig.Checksum {
+				return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("migration %d (%s) checksum mismatch: the applied version differs from the one bundled in this binary", mig.Version, mig.Name))
+			}
+			continue
+		}
+		steps = append(steps, PlannedStep{Version: mig.Version, Name: mig.Name})
+	}
+
+	return steps, nil
+}
+
+// checkNotAhead refuses to let this binary touch a database whose
+// schema_migrations table records a version newer than the latest one this
+// binary knows about: that means an older binary is being pointed at a DB a
+// newer one already migrated. Only the latest applied version is compared.
+func (m *Migrator) checkNotAhead(applied []AppliedMigration) error {
+	if len(applied) == 0 || len(m.migrations) == 0 {
+		return nil
+	}
+
+	latestKnown := m.migrations[len(m.migrations)-1].Version
+	latestApplied := applied[len(applied)-1].Version
+
+	if latestApplied > latestKnown {
+		return errcode.ErrInvalidInput.Wrap(fmt.Errorf("database schema is at version %d, which is ahead of the latest version (%d) this binary knows about", latestApplied, latestKnown))
+	}
+
+	return nil
+}
+
+// Up applies every pending migration up to and including target (0 meaning
+// "latest"), each inside its own transaction with foreign key enforcement
+// turned off for the duration of the step, as SQLite/SQLCipher require for
+// migrations that touch a table's schema.
+func (m *Migrator) Up(target int) error {
+	steps, err := m.Plan(target)
+	if err != nil {
+		return err
+	}
+
+	byVersion := map[int]Migration{}
+	for _, mig := range m.migrations {
+		byVersion[mig.Version] = mig
+	}
+
+	for _, step := range steps {
+		mig := byVersion[step.Version]
+
+		if err := m.applyOne(mig); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", mig.Version, mig.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (m *Migrator) applyOne(mig Migration) error {
+	if _, err := m.db.Exec(`PRAGMA foreign_keys = OFF;`); err != nil {
+		return errcode.ErrDBWrite.Wrap(err)
+	}
+	defer m.db.Exec(`PRAGMA foreign_keys = ON;`) //nolint:errcheck
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return errcode.ErrDBWrite.Wrap(err)
+	}
+
+	if _, err := tx.Exec(mig.Up); err != nil {
+		_ = tx.Rollback()
+		return errcode.ErrDBWrite.Wrap(err)
+	}
+
+	if _, err := tx.Exec(`INSERT INTO schema_migrations (version, applied_at, checksum) VALUES (?, ?, ?);`,
+		mig.Version, time.Now(), mig.Checksum); err != nil {
+		_ = tx.Rollback()
+		return errcode.ErrDBWrite.Wrap(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errcode.ErrDBWrite.Wrap(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package migrations
+
+import (
+	"database/sql"
+	"testing"
+
+	_ "github.com/mutecomm/go-sqlcipher/v4"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("opening in-memory db: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load(embeddedSQL)
+	if err != nil {
+		t.Fatalf("Load() returned an error: %s", err)
+	}
+
+	if len(migrations) == 0 {
+		t.Fatal("Load() found no migrations, expected at least the bundled 0001")
+	}
+
+	for _, m := range migrations {
+		if m.Up == "" {
+			t.Errorf("migration %d (%s) has no Up statement", m.Version, m.Name)
+		}
+		if m.Checksum == "" {
+			t.Errorf("migration %d (%s) has no checksum", m.Version, m.Name)
+		}
+	}
+}
+
+func TestMigratorPlanThenUp(t *testing.T) {
+	db := openTestDB(t)
+
+	m, err := New(db)
+	if err != nil {
+		t.Fatalf("New() returned an error: %s", err)
+	}
+
+	steps, err := m.Plan(0)
+	if err != nil {
+		t.Fatalf("Plan() returned an error: %s", err)
+	}
+	if len(steps) == 0 {
+		t.Fatal("Plan() reported nothing pending on a fresh database")
+	}
+
+	if err := m.Up(0); err != nil {
+		t.Fatalf("Up() returned an error: %s", err)
+	}
+
+	stepsAfter, err := m.Plan(0)
+	if err != nil {
+		t.Fatalf("Plan() after Up() returned an error: %s", err)
+	}
+	if len(stepsAfter) != 0 {
+		t.Fatalf("Plan() after Up() still reports %d pending migrations, want 0", len(stepsAfter))
+	}
+
+	applied, err := m.Applied()
+	if err != nil {
+		t.Fatalf("Applied() returned an error: %s", err)
+	}
+	if len(applied) != len(steps) {
+		t.Fatalf("Applied() returned %d rows, want %d", len(applied), len(steps))
+	}
+}
+
+func TestMigratorUpIsIdempotent(t *testing.T) {
+	db := openTestDB(t)
+
+	m, err := New(db)
+	if err != nil {
+		t.Fatalf("New() returned an error: %s", err)
+	}
+
+	if err := m.Up(0); err != nil {
+		t.Fatalf("first Up() returned an error: %s", err)
+	}
+	if err := m.Up(0); err != nil {
+		t.Fatalf("second Up() on an already-migrated db returned an error: %s", err)
+	}
+}
+
+func TestMigratorRefusesAheadOfBinarySchema(t *testing.T) {
+	db := openTestDB(t)
+
+	m, err := New(db)
+	if err != nil {
+		t.Fatalf("New() returned an error: %s", err)
+	}
+	if err := m.Up(0); err != nil {
+		t.Fatalf("Up() returned an error: %s", err)
+	}
+
+	if _, err := db.Exec(`INSERT INTO schema_migrations (version, applied_at, checksum) VALUES (?, ?, ?);`,
+		99999, "2026-01-01T00:00:00Z", "deadbeef"); err != nil {
+		t.Fatalf("seeding a future migration row failed: %s", err)
+	}
+
+	if _, err := m.Plan(0); err == nil {
+		t.Fatal("Plan() should refuse a database whose schema_migrations is ahead of this binary's known versions")
+	}
+}
@@ -0,0 +1,182 @@
+package accountutils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"berty.tech/berty/v2/go/internal/accountutils/migrations"
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+// Info reports what OpenMessengerDB actually did, for callers that want to
+// tell the user what happened rather than just getting a ready-to-use
+// *gorm.DB — e.g. a future `berty account migrate` CLI command, which is
+// NOT part of this package: this tree has no `berty` CLI/cobra command tree
+// to hook it into, so only the library surface (this type, DryRun below,
+// and Migrator.Plan) is provided here. Wiring an actual subcommand is left
+// to whoever adds it alongside the rest of the `berty account` commands.
+//
+// The `berty account migrate --dry-run` command itself was part of the
+// original request and has not shipped here — that's a reduction in scope,
+// not something signed off as done. This needs either maintainer sign-off
+// to close the request on the library surface alone, or the CLI command
+// split out as its own follow-up.
+type Info struct {
+	// AppliedVersions lists the migrations that were applied during this
+	// call, in order. Empty if the database was already up to date.
+	AppliedVersions []int
+
+	// SnapshotPath is the pre-migration SQLCipher snapshot OpenMessengerDB
+	// took before applying any migration, or "" if none were applied.
+	SnapshotPath string
+}
+
+// OpenMessengerDBOptions configures OpenMessengerDB.
+type OpenMessengerDBOptions struct {
+	// Target pins the migration target version instead of running to
+	// latest. Zero means latest.
+	Target int
+
+	// DryRun plans but does not apply migrations, and does not open the
+	// database for use: Info.AppliedVersions reports what *would* be
+	// applied. Intended for a future `berty account migrate --dry-run`
+	// command (see the Info doc comment above for why that command isn't
+	// shipped in this package).
+	DryRun bool
+}
+
+// OpenMessengerDB opens dir/messenger.sqlite, decrypting it with keyProvider,
+// migrating it to Target (or latest) if needed, and returns the ready
+// *gorm.DB alongside an Info describing what migration work happened and a
+// close func. Before applying any migration it snapshots the
+// still-encrypted file to messenger.sqlite.bak-<version>, so a failed
+// upgrade leaves a recoverable copy behind instead of a half-migrated file.
+func OpenMessengerDB(ctx context.Context, dir string, keyProvider StorageKeyProvider, logger *zap.Logger, opts OpenMessengerDBOptions) (*gorm.DB, Info, func(), error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	dbPath := dir
+	if dir != InMemoryDir {
+		dbPath = filepath.Join(dir, MessengerDatabaseFilename)
+	}
+
+	db, closeDB, err := GetGormDBForPath(dbPath, keyProvider, logger)
+	if err != nil {
+		return nil, Info{}, nil, err
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		closeDB()
+		return nil, Info{}, nil, errcode.ErrDBOpen.Wrap(err)
+	}
+
+	migrator, err := migrations.New(sqlDB)
+	if err != nil {
+		closeDB()
+		return nil, Info{}, nil, err
+	}
+
+	steps, err := migrator.Plan(opts.Target)
+	if err != nil {
+		closeDB()
+		return nil, Info{}, nil, err
+	}
+
+	pending := make(map[int]bool, len(steps))
+	for _, step := range steps {
+		pending[step.Version] = true
+	}
+
+	info := Info{}
+
+	if len(steps) == 0 && !opts.DryRun {
+		// Already up to date: nothing to snapshot or apply.
+		return db, info, closeDB, nil
+	}
+
+	if opts.DryRun {
+		// Nothing has run yet: report what Up would apply, not what it did.
+		for _, step := range steps {
+			info.AppliedVersions = append(info.AppliedVersions, step.Version)
+		}
+		closeDB()
+		return nil, info, func() {}, nil
+	}
+
+	if dir != InMemoryDir {
+		snapshotPath, err := snapshotEncryptedFile(dbPath, steps[len(steps)-1].Version)
+		if err != nil {
+			closeDB()
+			return nil, Info{}, nil, err
+		}
+		info.SnapshotPath = snapshotPath
+		logger.Info("messenger db: wrote pre-migration snapshot", zap.String("path", snapshotPath))
+	}
+
+	upErr := migrator.Up(opts.Target)
+
+	// Applied() reflects what's actually committed to schema_migrations,
+	// regardless of whether Up ran to completion: if it fails partway
+	// through (step 2 of 3), AppliedVersions must report only the one step
+	// that committed, not every step that was planned, or a caller building
+	// a rollback message around it is misled about what actually happened.
+	if applied, err := migrator.Applied(); err == nil {
+		info.AppliedVersions = appliedVersionsFromThisCall(applied, pending)
+	}
+
+	if upErr != nil {
+		closeDB()
+		return nil, info, nil, errcode.ErrDBWrite.Wrap(fmt.Errorf("migration failed, restore from %q to roll back: %w", info.SnapshotPath, upErr))
+	}
+
+	return db, info, closeDB, nil
+}
+
+// appliedVersionsFromThisCall narrows applied (every migration ever
+// recorded against the database) down to the ones pending identifies as
+// part of this OpenMessengerDB call, in the order Applied() returned them
+// (ascending by version).
+func appliedVersionsFromThisCall(applied []migrations.AppliedMigration, pending map[int]bool) []int {
+	var versions []int
+	for _, a := range applied {
+		if pending[a.Version] {
+			versions = append(versions, a.Version)
+		}
+	}
+	return versions
+}
+
+// snapshotEncryptedFile copies the still-encrypted database file at dbPath
+// to dbPath.bak-<version>, byte for byte, before any migration touches it.
+func snapshotEncryptedFile(dbPath string, version int) (string, error) {
+	snapshotPath := fmt.Sprintf("%s.bak-%d", dbPath, version)
+
+	src, err := os.Open(dbPath)
+	if os.IsNotExist(err) {
+		// Nothing to snapshot yet: this is a brand new database.
+		return "", nil
+	} else if err != nil {
+		return "", errcode.ErrBertyAccountFSError.Wrap(err)
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(snapshotPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return "", errcode.ErrBertyAccountFSError.Wrap(err)
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		return "", errcode.ErrBertyAccountFSError.Wrap(err)
+	}
+
+	return snapshotPath, nil
+}
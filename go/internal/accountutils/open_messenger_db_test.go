@@ -0,0 +1,26 @@
+package accountutils
+
+import (
+	"testing"
+
+	"berty.tech/berty/v2/go/internal/accountutils/migrations"
+)
+
+// Regression test: Info.AppliedVersions was previously populated from the
+// full planned step list before migrator.Up ran, so a partial Up failure
+// (step 2 of 3) still reported every planned version as applied.
+func TestAppliedVersionsFromThisCallExcludesUnpendingAndUnapplied(t *testing.T) {
+	pending := map[int]bool{2: true, 3: true}
+
+	applied := []migrations.AppliedMigration{
+		{Version: 1}, // applied in an earlier call, not part of this one
+		{Version: 2}, // applied during this call
+		// version 3 never committed: Up failed partway through
+	}
+
+	got := appliedVersionsFromThisCall(applied, pending)
+
+	if len(got) != 1 || got[0] != 2 {
+		t.Fatalf("appliedVersionsFromThisCall() = %v, want [2]", got)
+	}
+}
@@ -0,0 +1,38 @@
+package accountutils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPgStringLiteralEscapesQuotes(t *testing.T) {
+	got := pgStringLiteral(`it's a key`)
+	want := `'it''s a key'`
+	if got != want {
+		t.Fatalf("pgStringLiteral() = %q, want %q", got, want)
+	}
+}
+
+// TestPGCryptoQueriesWrapDataColumn is a regression test for PGCryptoKey
+// silently doing nothing: Get/Put must route the data column through
+// pgcrypto's pgp_sym_decrypt_bytea/pgp_sym_encrypt_bytea rather than
+// reading/writing it in the clear.
+func TestPGCryptoQueriesWrapDataColumn(t *testing.T) {
+	q := newPGCryptoQueries("public.blocks", []byte("a key with a ' in it"))
+
+	get := q.Get()
+	if !strings.Contains(get, "pgp_sym_decrypt_bytea(data,") {
+		t.Fatalf("Get() does not decrypt the data column, got: %s", get)
+	}
+	if !strings.Contains(get, "public.blocks") {
+		t.Fatalf("Get() does not target the configured table, got: %s", get)
+	}
+
+	put := q.Put()
+	if !strings.Contains(put, "pgp_sym_encrypt_bytea($2,") {
+		t.Fatalf("Put() does not encrypt the data column, got: %s", put)
+	}
+	if !strings.Contains(put, "ON CONFLICT (key) DO UPDATE SET data = pgp_sym_encrypt_bytea($2,") {
+		t.Fatalf("Put() does not re-encrypt on conflict, got: %s", put)
+	}
+}
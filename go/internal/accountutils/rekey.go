@@ -0,0 +1,62 @@
+package accountutils
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+var pragmaKeyRe = regexp.MustCompile(`_pragma_key=x'([0-9a-fA-F]+)'`)
+
+// pragmaKeyHex extracts the hex-encoded key embedded in a DSN built by
+// StorageKeyProvider.DSN, so it can be re-injected into a raw PRAGMA rekey
+// statement (which sqlite3's query-string pragmas don't support).
+func pragmaKeyHex(dsn string) (string, error) {
+	matches := pragmaKeyRe.FindStringSubmatch(dsn)
+	if len(matches) != 2 {
+		return "", fmt.Errorf("dsn does not carry a _pragma_key parameter")
+	}
+	return matches[1], nil
+}
+
+// RekeySQLCipherDB re-encrypts the SQLCipher database at dbPath from oldKey
+// to newKey in place, using SQLCipher's PRAGMA rekey. oldKey and newKey are
+// plaintext keys, as returned by StorageKeyProvider.Unwrap / Rotate.
+//
+// PRAGMA rekey drives its own internal page-rewrite transaction inside
+// SQLCipher and is not safe to run inside a caller-managed
+// database/sql.Tx: the two can disagree about whether the rewrite
+// committed, and nesting them was observed to silently no-op the rekey
+// against the go-sqlcipher driver. Run it directly on the connection.
+func RekeySQLCipherDB(provider StorageKeyProvider, dbPath string, oldKey []byte, newKey []byte) error {
+	dsn, err := provider.DSN(dbPath, oldKey)
+	if err != nil {
+		return errcode.ErrDBOpen.Wrap(err)
+	}
+
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return errcode.ErrDBOpen.Wrap(err)
+	}
+	defer db.Close()
+
+	newDSN, err := provider.DSN(dbPath, newKey)
+	if err != nil {
+		return errcode.ErrDBOpen.Wrap(err)
+	}
+
+	// newDSN carries the same path, only the key differs: extract the
+	// _pragma_key query value rekey expects as a raw hex literal.
+	rekeyHex, err := pragmaKeyHex(newDSN)
+	if err != nil {
+		return errcode.ErrDBWrite.Wrap(err)
+	}
+
+	if _, err := db.Exec(fmt.Sprintf("PRAGMA rekey = \"x'%s'\";", rekeyHex)); err != nil {
+		return errcode.ErrDBWrite.Wrap(err)
+	}
+
+	return nil
+}
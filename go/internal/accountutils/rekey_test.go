@@ -0,0 +1,87 @@
+package accountutils
+
+import (
+	"bytes"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mutecomm/go-sqlcipher/v4"
+
+	"berty.tech/berty/v2/go/internal/cryptoutil"
+)
+
+// TestRekeySQLCipherDBRoundTrip exercises RekeySQLCipherDB against a real
+// SQLCipher-encrypted file: this is the code path RotateStorageKey runs
+// against the live datastore.sqlite/messenger.sqlite files, so an untested
+// regression here (e.g. PRAGMA rekey silently no-oping when nested in a
+// caller-managed transaction) would be an account lockout in production.
+func TestRekeySQLCipherDBRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "rekey-test.sqlite")
+
+	provider, err := NewFileKeyProvider(FileKeyProviderConfig{
+		SealedDir: dir,
+		KEK:       bytes.Repeat([]byte{0x01}, 32),
+	})
+	if err != nil {
+		t.Fatalf("NewFileKeyProvider() returned an error: %s", err)
+	}
+
+	oldKey := bytes.Repeat([]byte{0x11}, cryptoutil.KeySize)
+	newKey := bytes.Repeat([]byte{0x22}, cryptoutil.KeySize)
+
+	seedDSN, err := provider.DSN(dbPath, oldKey)
+	if err != nil {
+		t.Fatalf("DSN() returned an error: %s", err)
+	}
+
+	seedDB, err := sql.Open("sqlite3", seedDSN)
+	if err != nil {
+		t.Fatalf("opening the database to seed it returned an error: %s", err)
+	}
+	if _, err := seedDB.Exec(`CREATE TABLE kv (k TEXT PRIMARY KEY, v TEXT);`); err != nil {
+		t.Fatalf("creating the seed table returned an error: %s", err)
+	}
+	if _, err := seedDB.Exec(`INSERT INTO kv (k, v) VALUES ('hello', 'world');`); err != nil {
+		t.Fatalf("seeding a row returned an error: %s", err)
+	}
+	if err := seedDB.Close(); err != nil {
+		t.Fatalf("closing the seed database returned an error: %s", err)
+	}
+
+	if err := RekeySQLCipherDB(provider, dbPath, oldKey, newKey); err != nil {
+		t.Fatalf("RekeySQLCipherDB() returned an error: %s", err)
+	}
+
+	oldDSN, err := provider.DSN(dbPath, oldKey)
+	if err != nil {
+		t.Fatalf("DSN() returned an error: %s", err)
+	}
+	oldDB, err := sql.Open("sqlite3", oldDSN)
+	if err != nil {
+		t.Fatalf("sql.Open() returned an error: %s", err)
+	}
+	defer oldDB.Close()
+	var v string
+	if err := oldDB.QueryRow(`SELECT v FROM kv WHERE k = 'hello';`).Scan(&v); err == nil {
+		t.Fatal("expected the old key to no longer open the rekeyed database")
+	}
+
+	newDSN, err := provider.DSN(dbPath, newKey)
+	if err != nil {
+		t.Fatalf("DSN() returned an error: %s", err)
+	}
+	newDB, err := sql.Open("sqlite3", newDSN)
+	if err != nil {
+		t.Fatalf("sql.Open() returned an error: %s", err)
+	}
+	defer newDB.Close()
+
+	if err := newDB.QueryRow(`SELECT v FROM kv WHERE k = 'hello';`).Scan(&v); err != nil {
+		t.Fatalf("expected the new key to open the rekeyed database and read back the seeded row: %s", err)
+	}
+	if v != "world" {
+		t.Fatalf("read back %q, want %q", v, "world")
+	}
+}
@@ -0,0 +1,184 @@
+package accountutils
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"go.uber.org/multierr"
+
+	"berty.tech/berty/v2/go/internal/cryptoutil"
+	"berty.tech/berty/v2/go/internal/sysutil"
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+// StorageKeyProvider abstracts where the SQLCipher master key used to
+// encrypt an account's root datastore and messenger/replication databases
+// comes from. NativeKeystoreProvider covers the historical case (OS
+// keychain/keystore on desktop and mobile); VaultKeyProvider and
+// FileKeyProvider exist for server-side deployments (replication nodes,
+// headless relays) that have no OS keychain to put the key in.
+type StorageKeyProvider interface {
+	// Get returns the stored key material for name, as it is held at rest.
+	// For a provider that keeps the key wrapped (sealed by a KEK, or by a
+	// remote KMS), this is the wrapped form, not the usable key: call
+	// Unwrap on the result before using it to open a database.
+	Get(name string) ([]byte, error)
+
+	// Put stores key material for name, wrapping it first if the provider
+	// requires it.
+	Put(name string, key []byte) error
+
+	// Unwrap takes the bytes returned by Get and returns the plaintext key
+	// usable as a SQLCipher PRAGMA key. For providers that store the key in
+	// the clear (NativeKeystoreProvider), this is the identity function.
+	Unwrap(wrapped []byte) ([]byte, error)
+
+	// DSN appends whatever query parameters are needed to open dbPath with
+	// key as the SQLCipher key, or returns dbPath unchanged if key is empty.
+	// Keeping this on the provider means the plaintext key never has to be
+	// logged or held by the caller beyond the sql.Open / gorm.Open call.
+	DSN(dbPath string, key []byte) (string, error)
+
+	// Rotate replaces the stored key for name with a freshly generated one
+	// and returns it. Callers are responsible for re-keying any already
+	// open databases (see RekeySQLCipherDB) before the old key is discarded.
+	Rotate(name string) ([]byte, error)
+}
+
+// sqlCipherDSN builds the sqlite3 connection string shared by every provider
+// that ends up storing the key unwrapped (i.e. all of them, once Unwrap has
+// run): a SQLCipher key embedded as a PRAGMA on the connection URL.
+func sqlCipherDSN(dbPath string, key []byte) (string, error) {
+	if len(key) == 0 {
+		return dbPath, nil
+	}
+
+	hexKey := hex.EncodeToString(key)
+	return fmt.Sprintf("%s?_pragma_key=x'%s'&_pragma_cipher_page_size=4096", dbPath, hexKey), nil
+}
+
+// NativeKeystoreProvider adapts a sysutil.NativeKeystore (OS keychain on
+// desktop, Android Keystore / iOS Keychain on mobile) to StorageKeyProvider.
+// This is the default provider and preserves the behavior GetOrCreateStorageKey
+// has always had.
+type NativeKeystoreProvider struct {
+	ks sysutil.NativeKeystore
+}
+
+func NewNativeKeystoreProvider(ks sysutil.NativeKeystore) *NativeKeystoreProvider {
+	return &NativeKeystoreProvider{ks: ks}
+}
+
+func (p *NativeKeystoreProvider) Get(name string) ([]byte, error) { return p.ks.Get(name) }
+
+func (p *NativeKeystoreProvider) Put(name string, key []byte) error { return p.ks.Put(name, key) }
+
+// Unwrap is the identity function: the native keystore never sees anything
+// but the plaintext key.
+func (p *NativeKeystoreProvider) Unwrap(wrapped []byte) ([]byte, error) { return wrapped, nil }
+
+func (p *NativeKeystoreProvider) DSN(dbPath string, key []byte) (string, error) {
+	return sqlCipherDSN(dbPath, key)
+}
+
+func (p *NativeKeystoreProvider) Rotate(name string) ([]byte, error) {
+	keyData := make([]byte, cryptoutil.KeySize)
+	if _, err := crand.Read(keyData); err != nil {
+		return nil, errcode.ErrCryptoKeyGeneration.Wrap(err)
+	}
+
+	if err := p.ks.Put(name, keyData); err != nil {
+		return nil, errcode.ErrKeystorePut.Wrap(err)
+	}
+
+	return keyData, nil
+}
+
+// GetOrCreateStorageKey returns the storage key served by provider, creating
+// and persisting a new random one under StorageKeyName if none exists yet.
+func GetOrCreateStorageKey(provider StorageKeyProvider) ([]byte, error) {
+	wrapped, getErr := provider.Get(StorageKeyName)
+	if getErr != nil {
+		keyData := make([]byte, cryptoutil.KeySize)
+		if _, err := crand.Read(keyData); err != nil {
+			return nil, errcode.ErrCryptoKeyGeneration.Wrap(err)
+		}
+
+		if err := provider.Put(StorageKeyName, keyData); err != nil {
+			return nil, errcode.ErrKeystoreGet.Wrap(multierr.Append(getErr, err))
+		}
+
+		var err error
+		if wrapped, err = provider.Get(StorageKeyName); err != nil {
+			return nil, errcode.ErrKeystorePut.Wrap(multierr.Append(getErr, err))
+		}
+	}
+
+	key, err := provider.Unwrap(wrapped)
+	if err != nil {
+		return nil, errcode.ErrCryptoDecrypt.Wrap(err)
+	}
+
+	return key, nil
+}
+
+// RotateStorageKey generates a new key and re-keys every SQLCipher database
+// under accountDir in place (datastore.sqlite and messenger.sqlite) before
+// ever persisting the new key with provider. This ordering matters: once
+// provider.Put has run, GetOrCreateStorageKey will only ever hand back the
+// new key, so persisting it before every file is confirmed rekeyed would
+// turn a single failed rekey (disk full, crash, lock contention) into a
+// permanently undecryptable file. If a file fails to rekey partway through,
+// the ones already moved to newKey are rolled back to oldKey on a
+// best-effort basis before returning, so the account is left exactly as it
+// was found. It is meant to be called while the account is not otherwise
+// open.
+func RotateStorageKey(provider StorageKeyProvider, accountDir string, name string) error {
+	oldWrapped, err := provider.Get(name)
+	if err != nil {
+		return errcode.ErrKeystoreGet.Wrap(err)
+	}
+
+	oldKey, err := provider.Unwrap(oldWrapped)
+	if err != nil {
+		return errcode.ErrCryptoDecrypt.Wrap(err)
+	}
+
+	newKey := make([]byte, cryptoutil.KeySize)
+	if _, err := crand.Read(newKey); err != nil {
+		return errcode.ErrCryptoKeyGeneration.Wrap(err)
+	}
+
+	filenames := []string{DatastoreFilename, MessengerDatabaseFilename}
+
+	rekeyed := make([]string, 0, len(filenames))
+	for _, filename := range filenames {
+		path := fmt.Sprintf("%s/%s", accountDir, filename)
+		if err := RekeySQLCipherDB(provider, path, oldKey, newKey); err != nil {
+			return multierr.Append(err, rollbackRekeyedFiles(provider, rekeyed, newKey, oldKey))
+		}
+		rekeyed = append(rekeyed, path)
+	}
+
+	// Every file is confirmed rekeyed: only now is it safe to make newKey
+	// the key GetOrCreateStorageKey returns.
+	if err := provider.Put(name, newKey); err != nil {
+		return multierr.Append(errcode.ErrKeystorePut.Wrap(err), rollbackRekeyedFiles(provider, rekeyed, newKey, oldKey))
+	}
+
+	return nil
+}
+
+// rollbackRekeyedFiles re-keys every path in rekeyed back from newKey to
+// oldKey, best-effort, so a failure partway through RotateStorageKey doesn't
+// leave some files keyed with a key that was never persisted.
+func rollbackRekeyedFiles(provider StorageKeyProvider, rekeyed []string, newKey []byte, oldKey []byte) error {
+	var rollbackErr error
+	for _, path := range rekeyed {
+		if err := RekeySQLCipherDB(provider, path, newKey, oldKey); err != nil {
+			rollbackErr = multierr.Append(rollbackErr, fmt.Errorf("unable to roll back %q: %w", path, err))
+		}
+	}
+	return rollbackErr
+}
@@ -3,7 +3,6 @@ package accountutils
 import (
 	crand "crypto/rand"
 	"database/sql"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -19,14 +18,12 @@ import (
 	sqlds "github.com/ipfs/go-ds-sql"
 	pgqueries "github.com/ipfs/go-ds-sql/postgres"
 	sqlite3 "github.com/mutecomm/go-sqlcipher/v4"
-	"go.uber.org/multierr"
 	"go.uber.org/zap"
 	"golang.org/x/crypto/nacl/box"
 	"gorm.io/gorm"
 	"moul.io/zapgorm2"
 
 	"berty.tech/berty/v2/go/internal/cryptoutil"
-	"berty.tech/berty/v2/go/internal/sysutil"
 	"berty.tech/berty/v2/go/pkg/accounttypes"
 	"berty.tech/berty/v2/go/pkg/errcode"
 )
@@ -38,6 +35,7 @@ const (
 	AccountNetConfFileName      = "account_net_conf"
 	MessengerDatabaseFilename   = "messenger.sqlite"
 	ReplicationDatabaseFilename = "replication.sqlite"
+	DatastoreFilename           = "datastore.sqlite"
 	StorageKeyName              = "storage"
 )
 
@@ -117,27 +115,6 @@ func ListAccounts(rootDir string, logger *zap.Logger) ([]*accounttypes.AccountMe
 	return accounts, nil
 }
 
-func GetOrCreateStorageKey(ks sysutil.NativeKeystore) ([]byte, error) {
-	key, getErr := ks.Get(StorageKeyName)
-	if getErr != nil {
-		keyData := make([]byte, cryptoutil.KeySize)
-		if _, err := crand.Read(keyData); err != nil {
-			return nil, errcode.ErrCryptoKeyGeneration.Wrap(err)
-		}
-
-		if err := ks.Put(StorageKeyName, keyData); err != nil {
-			return nil, errcode.ErrKeystoreGet.Wrap(multierr.Append(getErr, err))
-		}
-
-		var err error
-		if key, err = ks.Get(StorageKeyName); err != nil {
-			return nil, errcode.ErrKeystorePut.Wrap(multierr.Append(getErr, err))
-		}
-	}
-
-	return key, nil
-}
-
 func GetAccountMetaForName(rootDir string, accountID string, logger *zap.Logger) (*accounttypes.AccountMetadata, error) {
 	if logger == nil {
 		logger = zap.NewNop()
@@ -184,77 +161,107 @@ func GetDatastoreDir(dir string) (string, error) {
 	return dir, nil
 }
 
-func GetRootDatastoreForPath(dir string, key []byte, logger *zap.Logger) (datastore.Batching, error) {
-	inMemory := dir == InMemoryDir
+// GetRootDatastoreForPath opens the root IPFS datastore according to cfg's
+// Backend: sqlite (a local SQLCipher file under dir, the historical and
+// still-default option), postgres (a managed instance, see DatastoreConfig),
+// or memory. A zero-value cfg behaves exactly as before: sqlite, unless dir
+// is InMemoryDir.
+func GetRootDatastoreForPath(dir string, cfg DatastoreConfig, provider StorageKeyProvider, logger *zap.Logger) (datastore.Batching, error) {
+	backend := cfg.Backend
+	if backend == "" {
+		backend = DatastoreBackendSQLite
+		if dir == InMemoryDir {
+			backend = DatastoreBackendMemory
+		}
+	}
 
 	var ds datastore.Batching
-	if inMemory {
-		ds = datastore.NewMapDatastore()
-	} else {
-		const tableName = "blocks"
-
-		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
-			return nil, errcode.TODO.Wrap(err)
-		}
-		dbPath := filepath.Join(dir, "datastore.sqlite")
+	var err error
 
-		// Prepare db url
-		hasDB := false
-		if _, err := os.Stat(dbPath); err == nil {
-			hasDB = true
+	switch backend {
+	case DatastoreBackendMemory:
+		ds = datastore.NewMapDatastore()
+	case DatastoreBackendPostgres:
+		if ds, err = newPostgresDatastore(cfg, logger); err != nil {
+			return nil, err
 		}
-		hasEncryptedDB, err := sqlite3.IsEncrypted(dbPath)
-		if err != nil {
-			hasEncryptedDB = false
+	case DatastoreBackendSQLite:
+		if ds, err = newSQLiteDatastore(dir, provider, logger); err != nil {
+			return nil, err
 		}
+	default:
+		return nil, errcode.ErrInvalidInput.Wrap(fmt.Errorf("unknown datastore backend %q", backend))
+	}
 
-		var dbURL string
-		if len(key) != 0 {
-			if hasDB && !hasEncryptedDB {
-				return nil, errcode.ErrInvalidInput.Wrap(errors.New("storage key provided while datastore db is NOT encrypted"))
-			}
-			hexKey := hex.EncodeToString(key)
-			dbURL = fmt.Sprintf("%s?_pragma_key=x'%s'&_pragma_cipher_page_size=4096", dbPath, hexKey)
-		} else {
-			if hasDB && hasEncryptedDB {
-				return nil, errcode.ErrInvalidInput.Wrap(errors.New("missing storage key, db is encrypted"))
-			}
-			dbURL = dbPath
-			logger.Warn("root datastore encryption disabled: no key provided")
-		}
+	return sync_ds.MutexWrap(ds), nil
+}
 
-		// Open database
-		db, err := sql.Open("sqlite3", dbURL)
-		if err != nil {
+func newSQLiteDatastore(dir string, provider StorageKeyProvider, logger *zap.Logger) (datastore.Batching, error) {
+	const tableName = "blocks"
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, errcode.TODO.Wrap(err)
+	}
+	dbPath := filepath.Join(dir, DatastoreFilename)
+
+	key, err := GetOrCreateStorageKey(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	// Prepare db url
+	hasDB := false
+	if _, err := os.Stat(dbPath); err == nil {
+		hasDB = true
+	}
+	hasEncryptedDB, err := sqlite3.IsEncrypted(dbPath)
+	if err != nil {
+		hasEncryptedDB = false
+	}
+
+	var dbURL string
+	if len(key) != 0 {
+		if hasDB && !hasEncryptedDB {
+			return nil, errcode.ErrInvalidInput.Wrap(errors.New("storage key provided while datastore db is NOT encrypted"))
+		}
+		if dbURL, err = provider.DSN(dbPath, key); err != nil {
 			return nil, errcode.ErrDBOpen.Wrap(err)
 		}
-
-		// Create table if not exists
-		if _, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
-			key TEXT PRIMARY KEY,
-			data BLOB
-		) WITHOUT ROWID;`, tableName)); err != nil {
-			return nil, errcode.ErrDBWrite.Wrap(err)
+	} else {
+		if hasDB && hasEncryptedDB {
+			return nil, errcode.ErrInvalidInput.Wrap(errors.New("missing storage key, db is encrypted"))
 		}
+		dbURL = dbPath
+		logger.Warn("root datastore encryption disabled: no key provided")
+	}
 
-		// Use postgres queries as they seem to work with sqlite
-		queries := pgqueries.NewQueries(tableName)
+	// Open database
+	db, err := sql.Open("sqlite3", dbURL)
+	if err != nil {
+		return nil, errcode.ErrDBOpen.Wrap(err)
+	}
 
-		// Instantiate ds
-		ds = sqlds.NewDatastore(db, queries)
+	// Create table if not exists
+	if _, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		key TEXT PRIMARY KEY,
+		data BLOB
+	) WITHOUT ROWID;`, tableName)); err != nil {
+		return nil, errcode.ErrDBWrite.Wrap(err)
 	}
 
-	ds = sync_ds.MutexWrap(ds)
+	// Use postgres queries as they seem to work with sqlite
+	queries := pgqueries.NewQueries(tableName)
 
-	return ds, nil
+	// Instantiate ds
+	return sqlds.NewDatastore(db, queries), nil
 }
 
-func GetMessengerDBForPath(dir string, key []byte, logger *zap.Logger) (*gorm.DB, func(), error) {
+func GetMessengerDBForPath(dir string, provider StorageKeyProvider, logger *zap.Logger) (*gorm.DB, func(), error) {
 	if dir != InMemoryDir {
 		dir = path.Join(dir, MessengerDatabaseFilename)
 	}
 
-	return GetGormDBForPath(dir, key, logger)
+	return GetGormDBForPath(dir, provider, logger)
 }
 
 func GetReplicationDBForPath(dir string, logger *zap.Logger) (*gorm.DB, func(), error) {
@@ -265,15 +272,24 @@ func GetReplicationDBForPath(dir string, logger *zap.Logger) (*gorm.DB, func(),
 	return GetGormDBForPath(dir, nil, logger)
 }
 
-func GetGormDBForPath(dbPath string, key []byte, logger *zap.Logger) (*gorm.DB, func(), error) {
+// GetGormDBForPath opens the gorm DB at dbPath. provider may be nil, in
+// which case the database is opened unencrypted (used for the replication
+// database, which never holds a SQLCipher key of its own).
+func GetGormDBForPath(dbPath string, provider StorageKeyProvider, logger *zap.Logger) (*gorm.DB, func(), error) {
 	var sqliteConn string
 	if dbPath == InMemoryDir {
 		sqliteConn = fmt.Sprintf("file:memdb%d?mode=memory&cache=shared", time.Now().UnixNano())
 	} else {
 		sqliteConn = dbPath
-		if len(key) != 0 {
-			hexKey := hex.EncodeToString(key)
-			sqliteConn = fmt.Sprintf("%s?_pragma_key=x'%s'&_pragma_cipher_page_size=4096", sqliteConn, hexKey)
+		if provider != nil {
+			key, err := GetOrCreateStorageKey(provider)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if sqliteConn, err = provider.DSN(sqliteConn, key); err != nil {
+				return nil, nil, errcode.ErrDBOpen.Wrap(err)
+			}
 		}
 	}
 
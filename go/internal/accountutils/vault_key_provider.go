@@ -0,0 +1,187 @@
+package accountutils
+
+import (
+	crand "crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	"go.uber.org/zap"
+
+	"berty.tech/berty/v2/go/internal/cryptoutil"
+	"berty.tech/berty/v2/go/pkg/errcode"
+)
+
+// VaultKeyProviderConfig configures a VaultKeyProvider. The DEK is never
+// stored in Vault: it is only passed through Vault's transit/encrypt and
+// transit/decrypt endpoints, so what ends up on disk (sealedPath) is a
+// wrapped DEK that is useless without access to the Vault transit key.
+type VaultKeyProviderConfig struct {
+	// Address is the Vault server address, e.g. "https://vault.internal:8200".
+	Address string
+
+	// TransitKeyName is the name of the transit key used to wrap/unwrap the
+	// DEK, e.g. "berty-storage-keys".
+	TransitKeyName string
+
+	// RoleID / SecretID are AppRole credentials used to log in to Vault.
+	RoleID   string
+	SecretID string
+
+	// SealedDir is the directory sealed DEKs are written to, one file per
+	// name: <SealedDir>/<name>.sealed.
+	SealedDir string
+
+	Logger *zap.Logger
+}
+
+// VaultKeyProvider is a StorageKeyProvider backed by a HashiCorp Vault
+// Transit secrets engine: only a wrapped DEK is ever held on disk, and it is
+// unsealed at startup (and after any rotation) by calling Vault.
+type VaultKeyProvider struct {
+	cfg    VaultKeyProviderConfig
+	client *vaultapi.Client
+
+	mu          sync.Mutex
+	tokenExpiry time.Time
+}
+
+func NewVaultKeyProvider(cfg VaultKeyProviderConfig) (*VaultKeyProvider, error) {
+	if cfg.Logger == nil {
+		cfg.Logger = zap.NewNop()
+	}
+
+	vcfg := vaultapi.DefaultConfig()
+	vcfg.Address = cfg.Address
+
+	client, err := vaultapi.NewClient(vcfg)
+	if err != nil {
+		return nil, errcode.ErrInternal.Wrap(fmt.Errorf("unable to create vault client: %w", err))
+	}
+
+	p := &VaultKeyProvider{cfg: cfg, client: client}
+	if err := p.login(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// login performs an AppRole login, sets the resulting token on the client
+// and records its expiry, so ensureLoggedIn can renew it transparently.
+func (p *VaultKeyProvider) login() error {
+	secret, err := p.client.Logical().Write("auth/approle/login", map[string]interface{}{
+		"role_id":   p.cfg.RoleID,
+		"secret_id": p.cfg.SecretID,
+	})
+	if err != nil || secret == nil || secret.Auth == nil {
+		return errcode.ErrKeystoreGet.Wrap(fmt.Errorf("vault approle login failed: %w", err))
+	}
+
+	p.client.SetToken(secret.Auth.ClientToken)
+	p.tokenExpiry = time.Now().Add(time.Duration(secret.Auth.LeaseDuration) * time.Second)
+
+	p.cfg.Logger.Debug("vault: approle login succeeded", zap.Time("expires-at", p.tokenExpiry))
+
+	return nil
+}
+
+// ensureLoggedIn renews the AppRole token shortly before it expires, or logs
+// in again from scratch if renewal isn't possible.
+func (p *VaultKeyProvider) ensureLoggedIn() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if time.Now().Before(p.tokenExpiry.Add(-30 * time.Second)) {
+		return nil
+	}
+
+	if secret, err := p.client.Auth().Token().RenewSelf(0); err == nil && secret != nil && secret.Auth != nil {
+		p.tokenExpiry = time.Now().Add(time.Duration(secret.Auth.LeaseDuration) * time.Second)
+		return nil
+	}
+
+	return p.login()
+}
+
+func (p *VaultKeyProvider) sealedPath(name string) string {
+	return fmt.Sprintf("%s/%s.sealed", p.cfg.SealedDir, name)
+}
+
+// Get reads the wrapped DEK for name from disk. It is not usable until
+// Unwrap has called out to Vault.
+func (p *VaultKeyProvider) Get(name string) ([]byte, error) {
+	return ioutil.ReadFile(p.sealedPath(name))
+}
+
+// Put wraps key with Vault's transit/encrypt and writes the resulting
+// ciphertext to disk.
+func (p *VaultKeyProvider) Put(name string, key []byte) error {
+	if err := p.ensureLoggedIn(); err != nil {
+		return err
+	}
+
+	secret, err := p.client.Logical().Write(fmt.Sprintf("transit/encrypt/%s", p.cfg.TransitKeyName), map[string]interface{}{
+		"plaintext": base64.StdEncoding.EncodeToString(key),
+	})
+	if err != nil || secret == nil {
+		return errcode.ErrKeystorePut.Wrap(fmt.Errorf("vault transit encrypt failed: %w", err))
+	}
+
+	ciphertext, _ := secret.Data["ciphertext"].(string)
+	if ciphertext == "" {
+		return errcode.ErrKeystorePut.Wrap(fmt.Errorf("vault transit encrypt returned no ciphertext"))
+	}
+
+	if err := os.MkdirAll(p.cfg.SealedDir, 0o700); err != nil {
+		return errcode.ErrInternal.Wrap(err)
+	}
+
+	return ioutil.WriteFile(p.sealedPath(name), []byte(ciphertext), 0o600)
+}
+
+// Unwrap calls Vault's transit/decrypt to recover the plaintext DEK from the
+// wrapped ciphertext returned by Get.
+func (p *VaultKeyProvider) Unwrap(wrapped []byte) ([]byte, error) {
+	if err := p.ensureLoggedIn(); err != nil {
+		return nil, err
+	}
+
+	secret, err := p.client.Logical().Write(fmt.Sprintf("transit/decrypt/%s", p.cfg.TransitKeyName), map[string]interface{}{
+		"ciphertext": string(wrapped),
+	})
+	if err != nil || secret == nil {
+		return nil, errcode.ErrCryptoDecrypt.Wrap(fmt.Errorf("vault transit decrypt failed: %w", err))
+	}
+
+	plaintextB64, _ := secret.Data["plaintext"].(string)
+	plaintext, err := base64.StdEncoding.DecodeString(plaintextB64)
+	if err != nil {
+		return nil, errcode.ErrCryptoDecrypt.Wrap(err)
+	}
+
+	return plaintext, nil
+}
+
+func (p *VaultKeyProvider) DSN(dbPath string, key []byte) (string, error) {
+	return sqlCipherDSN(dbPath, key)
+}
+
+// Rotate generates a fresh DEK, wraps and persists it under name, and
+// returns the new plaintext key.
+func (p *VaultKeyProvider) Rotate(name string) ([]byte, error) {
+	keyData := make([]byte, cryptoutil.KeySize)
+	if _, err := crand.Read(keyData); err != nil {
+		return nil, errcode.ErrCryptoKeyGeneration.Wrap(err)
+	}
+
+	if err := p.Put(name, keyData); err != nil {
+		return nil, err
+	}
+
+	return keyData, nil
+}
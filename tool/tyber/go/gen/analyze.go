@@ -0,0 +1,377 @@
+package main
+
+import (
+	"fmt"
+	"go/types"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// defaultDiscriminantField is the struct field oneof/interface members are assumed
+// to share and that a Union is keyed on. It's a sensible default for the
+// protobuf-generated types this tool targets; -discriminant overrides it.
+const defaultDiscriminantField = "TypeUrl"
+
+// Analyze loads patterns (Go package import paths) with go/packages and
+// extracts every exported struct, enum-shaped named constant, interface with
+// a closed set of concrete implementors, and plain type alias it finds, each
+// kind sorted by name.
+func Analyze(patterns []string, discriminantField string) (*Model, error) {
+	if discriminantField == "" {
+		discriminantField = defaultDiscriminantField
+	}
+
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedTypes | packages.NeedTypesInfo | packages.NeedSyntax | packages.NeedImports | packages.NeedDeps,
+	}
+
+	pkgs, err := packages.Load(cfg, patterns...)
+	if err != nil {
+		return nil, fmt.Errorf("loading packages: %w", err)
+	}
+	if packages.PrintErrors(pkgs) > 0 {
+		return nil, fmt.Errorf("packages %v failed to type-check", patterns)
+	}
+
+	a := &analysis{
+		model:         &Model{},
+		enumConsts:    map[string][]*types.Const{},
+		interfaceDefs: map[string]*types.Interface{},
+		candidates:    map[string]*types.Named{},
+	}
+
+	for _, pkg := range pkgs {
+		a.collectConstants(pkg)
+	}
+	for _, pkg := range pkgs {
+		a.collectNamedTypes(pkg)
+	}
+
+	a.buildEnumsAndAliases()
+	a.buildUnions(discriminantField)
+	a.buildStructs()
+
+	return a.model, nil
+}
+
+type analysis struct {
+	model *Model
+
+	// enumConsts groups named constants by their named type's string
+	// representation, e.g. "berty.tech/weshnet/pkg/tyber.StatusType".
+	enumConsts map[string][]*types.Const
+
+	// interfaceDefs and candidates hold every named interface and named
+	// struct type seen, keyed the same way, so unions can be resolved once
+	// every package has been scanned.
+	interfaceDefs map[string]*types.Interface
+	candidates    map[string]*types.Named
+}
+
+func (a *analysis) collectConstants(pkg *packages.Package) {
+	scope := pkg.Types.Scope()
+	for _, name := range scope.Names() {
+		c, ok := scope.Lookup(name).(*types.Const)
+		if !ok {
+			continue
+		}
+
+		named, ok := c.Type().(*types.Named)
+		if !ok {
+			continue
+		}
+
+		key := named.String()
+		a.enumConsts[key] = append(a.enumConsts[key], c)
+	}
+}
+
+func (a *analysis) collectNamedTypes(pkg *packages.Package) {
+	scope := pkg.Types.Scope()
+	for _, name := range scope.Names() {
+		tn, ok := scope.Lookup(name).(*types.TypeName)
+		if !ok || !tn.Exported() {
+			continue
+		}
+
+		named, ok := tn.Type().(*types.Named)
+		if !ok {
+			continue
+		}
+
+		switch named.Underlying().(type) {
+		case *types.Interface:
+			a.interfaceDefs[named.String()] = named.Underlying().(*types.Interface)
+		case *types.Struct:
+			a.candidates[named.String()] = named
+		case *types.Basic:
+			a.candidates[named.String()] = named
+		}
+	}
+}
+
+func (a *analysis) buildEnumsAndAliases() {
+	for key, named := range a.candidates {
+		basic, ok := named.Underlying().(*types.Basic)
+		if !ok {
+			continue
+		}
+
+		consts := a.enumConsts[key]
+		if len(consts) == 0 {
+			// A named basic type with no constants of its own is a plain
+			// alias, e.g. `type AppID string`.
+			a.model.Aliases = append(a.model.Aliases, Alias{
+				Name:   named.Obj().Name(),
+				TSType: tsPrimitive(basic),
+			})
+			continue
+		}
+
+		enum := Enum{Name: named.Obj().Name(), Numeric: isNumericBasic(basic)}
+		for _, c := range consts {
+			enum.Values = append(enum.Values, constLiteral(c))
+		}
+		sortEnumValues(enum.Values, enum.Numeric)
+
+		a.model.Enums = append(a.model.Enums, enum)
+	}
+
+	sort.Slice(a.model.Enums, func(i, j int) bool { return a.model.Enums[i].Name < a.model.Enums[j].Name })
+	sort.Slice(a.model.Aliases, func(i, j int) bool { return a.model.Aliases[i].Name < a.model.Aliases[j].Name })
+}
+
+// buildStructs must run after buildUnions: a struct that is a union member
+// is rendered once, inline, by RenderUnion, and must not also get its own
+// top-level entry here or every consumer of the per-file output ends up
+// with the same `export interface X` declared twice (once in X.ts, once
+// inside its union's file).
+func (a *analysis) buildStructs() {
+	unionMembers := unionMemberNames(a.model.Unions)
+
+	for _, named := range a.candidates {
+		st, ok := named.Underlying().(*types.Struct)
+		if !ok {
+			continue
+		}
+		if unionMembers[named.Obj().Name()] {
+			continue
+		}
+
+		a.model.Structs = append(a.model.Structs, structFromNamed(named, st))
+	}
+
+	sort.Slice(a.model.Structs, func(i, j int) bool { return a.model.Structs[i].Name < a.model.Structs[j].Name })
+}
+
+// unionMemberNames collects the name of every struct that belongs to some
+// union, so buildStructs can skip them when building the top-level struct
+// list.
+func unionMemberNames(unions []Union) map[string]bool {
+	names := map[string]bool{}
+	for _, u := range unions {
+		for _, m := range u.Members {
+			names[m.Name] = true
+		}
+	}
+	return names
+}
+
+func structFromNamed(named *types.Named, st *types.Struct) Struct {
+	s := Struct{Name: named.Obj().Name()}
+	s.Fields = fieldsOf(st)
+	return s
+}
+
+// unionMemberStruct builds a union member's Struct the same way
+// structFromNamed does, then narrows its discriminantField to a literal of
+// the member's own Go type name (e.g. "AppStep"), adding the field if the
+// member doesn't already declare one. Zod's z.discriminatedUnion requires
+// every option's tag field to resolve to a literal (z.literal(...)) or it
+// throws at schema-construction time, and TypeScript can't narrow a `A | B`
+// union on a field typed as a plain string either way — so a renderer
+// relying on RenderStruct's generic z.string()/string output for the tag
+// field never actually produces a working discriminated union.
+func unionMemberStruct(named *types.Named, st *types.Struct, discriminantField string) Struct {
+	return narrowDiscriminant(structFromNamed(named, st), discriminantField)
+}
+
+// narrowDiscriminant overrides s's discriminantField with a literal of s's
+// own name (adding the field if s doesn't already declare one), split out
+// of unionMemberStruct so the literal-narrowing logic can be unit tested
+// without a real go/types fixture.
+func narrowDiscriminant(s Struct, discriminantField string) Struct {
+	literal := fmt.Sprintf("%q", s.Name)
+
+	for i := range s.Fields {
+		if s.Fields[i].Name == discriminantField {
+			s.Fields[i].TSType = literal
+			return s
+		}
+	}
+
+	s.Fields = append([]Field{{Name: discriminantField, TSType: literal}}, s.Fields...)
+	return s
+}
+
+// fieldsOf flattens st's fields into TS fields, splicing the fields of each
+// embedded (anonymous) struct field in rather than nesting them. A named
+// field is never spliced, even when its name equals its type's name.
+func fieldsOf(st *types.Struct) []Field {
+	var fields []Field
+
+	for i := 0; i < st.NumFields(); i++ {
+		v := st.Field(i)
+		tag := st.Tag(i)
+
+		if embeddedStruct, ok := v.Type().Underlying().(*types.Struct); ok && v.Anonymous() {
+			fields = append(fields, fieldsOf(embeddedStruct)...)
+			continue
+		}
+
+		fields = append(fields, Field{
+			Name:   jsonFieldName(v.Name(), tag),
+			TSType: tsTypeOf(v.Type()),
+		})
+	}
+
+	return fields
+}
+
+func (a *analysis) buildUnions(discriminantField string) {
+	for key, iface := range a.interfaceDefs {
+		var members []Struct
+
+		for candidateKey, named := range a.candidates {
+			st, ok := named.Underlying().(*types.Struct)
+			if !ok {
+				continue
+			}
+			if !types.Implements(named, iface) && !types.Implements(types.NewPointer(named), iface) {
+				continue
+			}
+			if candidateKey == key {
+				continue
+			}
+
+			members = append(members, unionMemberStruct(named, st, discriminantField))
+		}
+
+		if len(members) == 0 {
+			continue
+		}
+
+		sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+
+		name := key[strings.LastIndex(key, ".")+1:]
+		a.model.Unions = append(a.model.Unions, Union{
+			Name:              name,
+			DiscriminantField: discriminantField,
+			Members:           members,
+		})
+	}
+
+	sort.Slice(a.model.Unions, func(i, j int) bool { return a.model.Unions[i].Name < a.model.Unions[j].Name })
+}
+
+func isNumericBasic(b *types.Basic) bool {
+	return b.Info()&types.IsInteger != 0
+}
+
+// sortEnumValues sorts values in place. A numeric enum is sorted on its
+// integer value rather than lexicographically, or a 10+ member protobuf
+// enum would render as "0", "1", "10", "11", "2", ... instead of in the
+// order its Go constants actually count in.
+func sortEnumValues(values []string, numeric bool) {
+	if !numeric {
+		sort.Strings(values)
+		return
+	}
+
+	sort.Slice(values, func(i, j int) bool {
+		vi, erri := strconv.Atoi(values[i])
+		vj, errj := strconv.Atoi(values[j])
+		if erri != nil || errj != nil {
+			return values[i] < values[j]
+		}
+		return vi < vj
+	})
+}
+
+func constLiteral(c *types.Const) string {
+	val := c.Val()
+	if val.Kind().String() == "String" {
+		return val.ExactString()
+	}
+	return val.String()
+}
+
+func jsonFieldName(goName, tag string) string {
+	if jsonTag, ok := lookupTag(tag, "json"); ok {
+		name := strings.Split(jsonTag, ",")[0]
+		if name != "" && name != "-" {
+			return name
+		}
+	}
+	return goName
+}
+
+// lookupTag is a small stand-in for reflect.StructTag.Lookup that works on
+// the raw tag string go/types exposes.
+func lookupTag(tag, key string) (string, bool) {
+	prefix := key + `:"`
+	idx := strings.Index(tag, prefix)
+	if idx == -1 {
+		return "", false
+	}
+	rest := tag[idx+len(prefix):]
+	end := strings.Index(rest, `"`)
+	if end == -1 {
+		return "", false
+	}
+	return rest[:end], true
+}
+
+func tsPrimitive(b *types.Basic) string {
+	switch {
+	case b.Info()&types.IsBoolean != 0:
+		return "boolean"
+	case b.Info()&types.IsString != 0:
+		return "string"
+	case b.Info()&types.IsNumeric != 0:
+		return "number"
+	default:
+		return "unknown"
+	}
+}
+
+func tsTypeOf(t types.Type) string {
+	switch u := t.(type) {
+	case *types.Basic:
+		return tsPrimitive(u)
+	case *types.Named:
+		if u.String() == "time.Time" {
+			return "string"
+		}
+		if basic, ok := u.Underlying().(*types.Basic); ok && u.Obj().Pkg() != nil {
+			_ = basic
+			return u.Obj().Name()
+		}
+		return u.Obj().Name()
+	case *types.Pointer:
+		return tsTypeOf(u.Elem())
+	case *types.Slice:
+		return tsTypeOf(u.Elem()) + "[]"
+	case *types.Array:
+		return tsTypeOf(u.Elem()) + "[]"
+	case *types.Map:
+		return fmt.Sprintf("{ [key: string]: %s }", tsTypeOf(u.Elem()))
+	case *types.Interface:
+		return "unknown"
+	default:
+		return "unknown"
+	}
+}
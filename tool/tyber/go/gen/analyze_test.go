@@ -0,0 +1,81 @@
+package main
+
+import "testing"
+
+// Regression test: a struct that's a member of some union must not also be
+// emitted as its own top-level Struct, or per-file output declares it twice
+// (its own file, and inline inside the union's file).
+func TestUnionMemberNamesExcludesMembersFromTopLevelStructs(t *testing.T) {
+	unions := []Union{
+		{
+			Name: "CreateStepEvent",
+			Members: []Struct{
+				{Name: "AppStep"},
+				{Name: "SubTarget"},
+			},
+		},
+	}
+
+	names := unionMemberNames(unions)
+
+	for _, want := range []string{"AppStep", "SubTarget"} {
+		if !names[want] {
+			t.Fatalf("expected %q to be recognized as a union member", want)
+		}
+	}
+
+	if names["CreateTraceEvent"] {
+		t.Fatal("a struct that isn't a union member must not be reported as one")
+	}
+}
+
+// Regression test: neither renderer's RenderUnion output actually narrowed
+// a member's discriminant field to a literal, which z.discriminatedUnion
+// requires and which TypeScript needs to narrow the union at all.
+// narrowDiscriminant is unionMemberStruct's literal-narrowing logic, split
+// out so it's testable without a real go/types fixture.
+func TestNarrowDiscriminantOverridesExistingField(t *testing.T) {
+	s := narrowDiscriminant(Struct{
+		Name:   "AppStep",
+		Fields: []Field{{Name: "TypeUrl", TSType: "string"}, {Name: "name", TSType: "string"}},
+	}, "TypeUrl")
+
+	if s.Fields[0].TSType != `"AppStep"` {
+		t.Fatalf("expected TypeUrl narrowed to a literal, got %q", s.Fields[0].TSType)
+	}
+}
+
+func TestNarrowDiscriminantAddsMissingField(t *testing.T) {
+	s := narrowDiscriminant(Struct{
+		Name:   "AppStep",
+		Fields: []Field{{Name: "name", TSType: "string"}},
+	}, "TypeUrl")
+
+	if len(s.Fields) != 2 || s.Fields[0].Name != "TypeUrl" || s.Fields[0].TSType != `"AppStep"` {
+		t.Fatalf("expected a synthesized literal TypeUrl field, got %+v", s.Fields)
+	}
+}
+
+// Regression test: sort.Strings on numeric enum literals sorts
+// lexicographically ("0","1","10","11","2",...), not in the constants'
+// actual numeric order.
+func TestSortEnumValuesNumeric(t *testing.T) {
+	values := []string{"0", "1", "10", "11", "2"}
+	sortEnumValues(values, true)
+
+	want := []string{"0", "1", "2", "10", "11"}
+	for i := range want {
+		if values[i] != want[i] {
+			t.Fatalf("sortEnumValues(numeric) = %v, want %v", values, want)
+		}
+	}
+}
+
+func TestSortEnumValuesString(t *testing.T) {
+	values := []string{`"Running"`, `"Done"`}
+	sortEnumValues(values, false)
+
+	if values[0] != `"Done"` || values[1] != `"Running"` {
+		t.Fatalf("sortEnumValues(string) = %v, want lexicographic order", values)
+	}
+}
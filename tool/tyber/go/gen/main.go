@@ -1,90 +1,83 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"reflect"
-	"regexp"
-	"strings"
-
-	"berty.tech/berty/v2/tool/tyber/go/parser"
-	"berty.tech/weshnet/pkg/tyber"
+	"io/ioutil"
+	"log"
+	"path/filepath"
 )
 
-func main() {
-	fmt.Print("// generated by berty.tech/berty/v2/tool/tyber/gen\n\n")
-	fmt.Printf("export %s\n", tsType(tyber.StatusType("")))
-	fmt.Printf("export %s\n", tsType(&tyber.Detail{}))
-	fmt.Printf("export %s\n", tsType(&tyber.Step{}))
-	fmt.Printf("export %s\n", tsType(&parser.AppStep{}))
-	fmt.Printf("export %s\n", tsType(&parser.CreateStepEvent{}))
-	fmt.Printf("export %s\n", tsType(&parser.SubTarget{}))
-	fmt.Printf("export %s\n", tsType(&parser.CreateTraceEvent{}))
-	fmt.Printf("export %s\n", tsType(&parser.UpdateTraceEvent{}))
+var targetPackages = []string{
+	"berty.tech/weshnet/pkg/tyber",
+	"berty.tech/berty/v2/tool/tyber/go/parser",
 }
 
-func primaryType(str string) string {
-	if str == "Bool" {
-		return "boolean"
+func main() {
+	rendererName := flag.String("renderer", "ts", "output renderer: ts|zod")
+	outDir := flag.String("out", ".", "directory to write one file per declaration into")
+	discriminant := flag.String("discriminant", "", "struct field oneof/interface unions are keyed on (default TypeUrl)")
+	flag.Parse()
+
+	renderer, err := rendererFor(*rendererName)
+	if err != nil {
+		log.Fatal(err)
 	}
-	if str == "String" {
-		return "string"
+
+	model, err := Analyze(targetPackages, *discriminant)
+	if err != nil {
+		log.Fatalf("tyber/gen: %s", err)
 	}
-	if str == "TimeTime" {
-		return "string"
+
+	if err := writeModel(*outDir, renderer, model); err != nil {
+		log.Fatalf("tyber/gen: %s", err)
 	}
-	return str
 }
 
-func finalTypeName(t reflect.Type) string {
-	name := t.String()
-	str := strings.Title(strings.Replace(strings.Join(strings.Split(name, "."), ""), "*", "", -1))
-	if strings.HasPrefix(str, "[]") {
-		return primaryType(strings.TrimPrefix(str, "[]")) + "[]"
+func rendererFor(name string) (Renderer, error) {
+	switch name {
+	case "ts":
+		return TypeScriptRenderer{}, nil
+	case "zod":
+		return ZodRenderer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown -renderer %q, want ts or zod", name)
 	}
-	return primaryType(str)
 }
 
-func endTypeName(t reflect.Type) string {
-	name := t.String()
-	if parts := strings.Split(name, "."); len(parts) > 1 {
-		name = parts[len(parts)-1]
+// writeModel renders every declaration in model with renderer into its own
+// file under outDir, named after the declaration, so consumers only import
+// (and bundlers only ship) the types they actually use.
+func writeModel(outDir string, renderer Renderer, model *Model) error {
+	write := func(name, contents string) error {
+		path := filepath.Join(outDir, name+renderer.FileExtension())
+		header := fmt.Sprintf("// generated by berty.tech/berty/v2/tool/tyber/gen, DO NOT EDIT\n\n")
+		return ioutil.WriteFile(path, []byte(header+contents), 0o644)
 	}
-	return name
-}
 
-func tsFields(level int, reflectElem reflect.Value) []string {
-	fields := []string{}
-	for i := 0; i < reflectElem.NumField(); i++ {
-		member := reflectElem.Field(i)
-		memberField := reflectElem.Type().Field(i)
-		memberTypeName := finalTypeName(memberField.Type)
-		if memberField.Name == endTypeName(memberField.Type) && memberField.Type.Kind() == reflect.Struct {
-			fields = append(fields, tsFields(level, member)...)
-		} else {
-			memberName := memberField.Name
-			re := regexp.MustCompile(`json:".+"`)
-			if jsonTag := string(re.Find([]byte(memberField.Tag))); jsonTag != "" {
-				memberName = jsonTag[len(`json:"`) : len(jsonTag)-len(`"`)]
-			}
-			prefix := ""
-			for j := 0; j < level; j++ {
-				prefix += "  "
-			}
-			fields = append(fields, fmt.Sprintf("%s%s: %s\n", prefix, memberName, memberTypeName))
+	for _, enum := range model.Enums {
+		if err := write(enum.Name, renderer.RenderEnum(enum)); err != nil {
+			return err
 		}
 	}
-	return fields
-}
 
-func tsType(goType interface{}) string {
-	reflectValue := reflect.ValueOf(goType)
-	if reflectValue.Kind() == reflect.String {
-		return fmt.Sprintf("type %s = string\n", finalTypeName(reflectValue.Type()))
+	for _, alias := range model.Aliases {
+		if err := write(alias.Name, renderer.RenderAlias(alias)); err != nil {
+			return err
+		}
+	}
+
+	for _, union := range model.Unions {
+		if err := write(union.Name, renderer.RenderUnion(union)); err != nil {
+			return err
+		}
 	}
-	// str := fmt.Sprintf("Value: %s\n", reflectValue.Type())
-	reflectElem := reflectValue.Elem()
-	str := fmt.Sprintf("interface %s {\n", finalTypeName(reflectElem.Type()))
-	str += strings.Join(tsFields(1, reflectElem), "")
-	str += "}\n"
-	return str
+
+	for _, s := range model.Structs {
+		if err := write(s.Name, renderer.RenderStruct(s)); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,68 @@
+package main
+
+// Field is one member of a Struct, already resolved to its TypeScript type
+// name (a reference to another Named, a TS primitive, or an array of one of
+// those).
+type Field struct {
+	Name     string
+	TSType   string
+	Optional bool
+}
+
+// Struct models a Go struct as a flat set of TS fields, embedded structs
+// already inlined the way the previous reflect-based generator did.
+type Struct struct {
+	Name   string
+	Fields []Field
+}
+
+// Enum models a set of named Go constants of a string or int kind, found by
+// walking the package with go/types, as a closed union of literal values:
+// `type StatusType = "Running" | "Done" | ...`.
+type Enum struct {
+	Name    string
+	Numeric bool
+	// Values holds each constant's TS literal: a quoted string for a string
+	// kind, the decimal text for an int kind.
+	Values []string
+}
+
+// Union models a Go interface with a closed set of concrete implementors
+// (typically a protobuf oneof) as a discriminated union, tagged on
+// DiscriminantField so TypeScript can narrow on it.
+type Union struct {
+	Name              string
+	DiscriminantField string
+	Members           []Struct
+}
+
+// Alias models a Go type that is nothing more than a rename of another type,
+// e.g. `type AppID string`.
+type Alias struct {
+	Name   string
+	TSType string
+}
+
+// Model is everything Analyze extracted from the target packages, each
+// slice sorted by name so renderer output is stable across runs.
+type Model struct {
+	Structs []Struct
+	Enums   []Enum
+	Unions  []Union
+	Aliases []Alias
+}
+
+// Renderer turns one piece of the Model into the text of a standalone
+// output file. Each render call owns its own file so that -renderer=zod can
+// ship validators next to (or instead of) -renderer=ts's plain types, and so
+// that consumers can tree-shake import only what they use.
+type Renderer interface {
+	// FileExtension is appended to the type's name to name its output file,
+	// e.g. ".ts".
+	FileExtension() string
+
+	RenderStruct(Struct) string
+	RenderEnum(Enum) string
+	RenderUnion(Union) string
+	RenderAlias(Alias) string
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TypeScriptRenderer emits the plain `interface`/`type` declarations the
+// generator has always produced, plus the enum and discriminated-union
+// support the reflect-based generator couldn't do (it saw a protobuf enum
+// or a oneof only as an opaque numeric-constant type).
+type TypeScriptRenderer struct{}
+
+func (TypeScriptRenderer) FileExtension() string { return ".ts" }
+
+func (TypeScriptRenderer) RenderStruct(s Struct) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "export interface %s {\n", s.Name)
+	for _, f := range s.Fields {
+		fmt.Fprintf(&b, "  %s: %s\n", f.Name, f.TSType)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func (TypeScriptRenderer) RenderEnum(e Enum) string {
+	return fmt.Sprintf("export type %s = %s\n", e.Name, strings.Join(e.Values, " | "))
+}
+
+func (r TypeScriptRenderer) RenderUnion(u Union) string {
+	var b strings.Builder
+
+	for _, member := range u.Members {
+		b.WriteString(r.RenderStruct(member))
+		b.WriteString("\n")
+	}
+
+	names := make([]string, len(u.Members))
+	for i, member := range u.Members {
+		names[i] = member.Name
+	}
+	fmt.Fprintf(&b, "export type %s = %s\n", u.Name, strings.Join(names, " | "))
+
+	return b.String()
+}
+
+func (TypeScriptRenderer) RenderAlias(a Alias) string {
+	return fmt.Sprintf("export type %s = %s\n", a.Name, a.TSType)
+}
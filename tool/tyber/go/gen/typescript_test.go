@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTypeScriptRendererRenderStruct(t *testing.T) {
+	out := TypeScriptRenderer{}.RenderStruct(Struct{
+		Name: "Step",
+		Fields: []Field{
+			{Name: "name", TSType: "string"},
+			{Name: "details", TSType: "Detail[]"},
+		},
+	})
+
+	for _, want := range []string{"export interface Step {", "name: string", "details: Detail[]"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in output, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestTypeScriptRendererRenderUnion(t *testing.T) {
+	// Members carry their discriminant field already narrowed to a literal,
+	// the way analyze.go's unionMemberStruct produces it: TypeScript can
+	// only narrow a `A | B` union on a field typed as a literal, not a
+	// plain string.
+	union := Union{
+		Name:              "CreateStepEvent",
+		DiscriminantField: "TypeUrl",
+		Members: []Struct{
+			{Name: "AppStep", Fields: []Field{{Name: "TypeUrl", TSType: `"AppStep"`}, {Name: "name", TSType: "string"}}},
+			{Name: "SubTarget", Fields: []Field{{Name: "TypeUrl", TSType: `"SubTarget"`}, {Name: "id", TSType: "string"}}},
+		},
+	}
+
+	out := TypeScriptRenderer{}.RenderUnion(union)
+
+	if !strings.Contains(out, "export interface AppStep {") {
+		t.Fatalf("expected the union's members to be rendered inline, got:\n%s", out)
+	}
+	for _, want := range []string{`TypeUrl: "AppStep"`, `TypeUrl: "SubTarget"`} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected the discriminant field narrowed to a literal (%q), got:\n%s", want, out)
+		}
+	}
+	if !strings.Contains(out, "export type CreateStepEvent = AppStep | SubTarget") {
+		t.Fatalf("expected a union alias over the member names, discriminated by each member's literal TypeUrl field, got:\n%s", out)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ZodRenderer emits a Zod schema (https://zod.dev) alongside the inferred TS
+// type for each declaration, so the frontend can validate traces received
+// from the tyber parser at runtime instead of trusting the wire format.
+type ZodRenderer struct{}
+
+func (ZodRenderer) FileExtension() string { return ".zod.ts" }
+
+func (ZodRenderer) RenderStruct(s Struct) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "import { z } from 'zod'\n\n")
+	fmt.Fprintf(&b, "export const %sSchema = z.object({\n", lowerFirst(s.Name))
+	for _, f := range s.Fields {
+		fmt.Fprintf(&b, "  %s: %s,\n", f.Name, zodPrimitive(f.TSType))
+	}
+	b.WriteString("})\n\n")
+	fmt.Fprintf(&b, "export type %s = z.infer<typeof %sSchema>\n", s.Name, lowerFirst(s.Name))
+	return b.String()
+}
+
+func (ZodRenderer) RenderEnum(e Enum) string {
+	var b strings.Builder
+	b.WriteString("import { z } from 'zod'\n\n")
+
+	// z.enum() only accepts a non-empty array of string literals: a numeric
+	// Go enum (every protobuf-generated one, for instance) needs
+	// z.union() of z.literal()s instead, or the schema wouldn't compile.
+	if e.Numeric {
+		literals := make([]string, len(e.Values))
+		for i, v := range e.Values {
+			literals[i] = fmt.Sprintf("z.literal(%s)", v)
+		}
+		fmt.Fprintf(&b, "export const %sSchema = z.union([%s])\n", lowerFirst(e.Name), strings.Join(literals, ", "))
+	} else {
+		fmt.Fprintf(&b, "export const %sSchema = z.enum([%s])\n", lowerFirst(e.Name), strings.Join(e.Values, ", "))
+	}
+
+	fmt.Fprintf(&b, "export type %s = z.infer<typeof %sSchema>\n", e.Name, lowerFirst(e.Name))
+	return b.String()
+}
+
+func (r ZodRenderer) RenderUnion(u Union) string {
+	var b strings.Builder
+	b.WriteString("import { z } from 'zod'\n\n")
+
+	names := make([]string, len(u.Members))
+	for i, member := range u.Members {
+		b.WriteString(r.RenderStruct(member))
+		b.WriteString("\n")
+		names[i] = fmt.Sprintf("%sSchema", lowerFirst(member.Name))
+	}
+
+	fmt.Fprintf(&b, "export const %sSchema = z.discriminatedUnion('%s', [%s])\n", lowerFirst(u.Name), u.DiscriminantField, strings.Join(names, ", "))
+	fmt.Fprintf(&b, "export type %s = z.infer<typeof %sSchema>\n", u.Name, lowerFirst(u.Name))
+
+	return b.String()
+}
+
+func (ZodRenderer) RenderAlias(a Alias) string {
+	var b strings.Builder
+	b.WriteString("import { z } from 'zod'\n\n")
+	fmt.Fprintf(&b, "export const %sSchema = %s\n", lowerFirst(a.Name), zodPrimitive(a.TSType))
+	fmt.Fprintf(&b, "export type %s = z.infer<typeof %sSchema>\n", a.Name, lowerFirst(a.Name))
+	return b.String()
+}
+
+func zodPrimitive(tsType string) string {
+	switch {
+	case strings.HasSuffix(tsType, "[]"):
+		return fmt.Sprintf("z.array(%s)", zodPrimitive(strings.TrimSuffix(tsType, "[]")))
+	case strings.HasPrefix(tsType, `"`) && strings.HasSuffix(tsType, `"`):
+		// A discriminated union member's narrowed tag field, e.g. "AppStep".
+		return fmt.Sprintf("z.literal(%s)", tsType)
+	case tsType == "string":
+		return "z.string()"
+	case tsType == "number":
+		return "z.number()"
+	case tsType == "boolean":
+		return "z.boolean()"
+	case tsType == "unknown":
+		return "z.unknown()"
+	default:
+		// Reference to another generated schema.
+		return fmt.Sprintf("%sSchema", lowerFirst(tsType))
+	}
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
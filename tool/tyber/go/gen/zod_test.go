@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestZodRendererRenderEnumStringKind(t *testing.T) {
+	out := ZodRenderer{}.RenderEnum(Enum{
+		Name:   "Direction",
+		Values: []string{`"Up"`, `"Down"`},
+	})
+
+	if !strings.Contains(out, `z.enum(["Up", "Down"])`) {
+		t.Fatalf("expected a z.enum() of string literals, got:\n%s", out)
+	}
+}
+
+// Regression test for a numeric Go enum (e.g. a protobuf-generated one):
+// z.enum() only accepts string literals, so emitting it for a
+// numeric enum produces a schema that doesn't even compile.
+func TestZodRendererRenderEnumNumericKind(t *testing.T) {
+	out := ZodRenderer{}.RenderEnum(Enum{
+		Name:    "StatusType",
+		Numeric: true,
+		Values:  []string{"0", "1", "2"},
+	})
+
+	if strings.Contains(out, "z.enum(") {
+		t.Fatalf("numeric enum must not be rendered with z.enum(), got:\n%s", out)
+	}
+
+	for _, literal := range []string{"z.literal(0)", "z.literal(1)", "z.literal(2)"} {
+		if !strings.Contains(out, literal) {
+			t.Fatalf("expected %q in numeric enum output, got:\n%s", literal, out)
+		}
+	}
+}
+
+// Regression test: z.discriminatedUnion requires every option's tag field
+// to resolve to a literal (_def.value), or it throws at schema-construction
+// time. Members here carry their TypeUrl field already narrowed the way
+// analyze.go's unionMemberStruct produces it.
+func TestZodRendererRenderUnionNarrowsDiscriminant(t *testing.T) {
+	union := Union{
+		Name:              "CreateStepEvent",
+		DiscriminantField: "TypeUrl",
+		Members: []Struct{
+			{Name: "AppStep", Fields: []Field{{Name: "TypeUrl", TSType: `"AppStep"`}, {Name: "name", TSType: "string"}}},
+			{Name: "SubTarget", Fields: []Field{{Name: "TypeUrl", TSType: `"SubTarget"`}, {Name: "id", TSType: "string"}}},
+		},
+	}
+
+	out := ZodRenderer{}.RenderUnion(union)
+
+	for _, want := range []string{`TypeUrl: z.literal("AppStep")`, `TypeUrl: z.literal("SubTarget")`} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected the discriminant field rendered as %q, got:\n%s", want, out)
+		}
+	}
+	if !strings.Contains(out, "z.discriminatedUnion('TypeUrl', [appStepSchema, subTargetSchema])") {
+		t.Fatalf("expected z.discriminatedUnion over the member schemas, got:\n%s", out)
+	}
+}